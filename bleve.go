@@ -13,6 +13,7 @@ import (
 	"github.com/blevesearch/bleve/analysis"
 	"github.com/blevesearch/bleve/analysis/char_filters/html_char_filter"
 	"github.com/blevesearch/bleve/analysis/language/en"
+	"github.com/blevesearch/bleve/document"
 	"github.com/blevesearch/bleve/registry"
 )
 
@@ -42,35 +43,92 @@ func buildHtmlDocumentMapping() *bleve.DocumentMapping {
 
 type Index interface {
 	Put(data *FileData) error
-	Query(terms []string) (*bleve.SearchResult, error)
+	Get(id string) (*FileData, error)
+	Delete(id string) error
+	Query(terms []string, opts QueryOptions) (*SearchResultDTO, error)
+	Count() (uint64, error)
 	Close() error
 }
 
 type bleveIndex struct {
-	index bleve.Index
+	index    bleve.Index
+	readOnly bool
 }
 
 func (i *bleveIndex) Put(data *FileData) error {
+	if i.readOnly {
+		return fmt.Errorf("Attempt to write to read-only index %q", i.index.Name())
+	}
 	if err := i.index.Index(data.FullPath, data); err != nil {
 		return fmt.Errorf("Error writing to index: %q", err)
 	}
 	return nil
 }
 
-func (i *bleveIndex) Query(terms []string) (*bleve.SearchResult, error) {
+func (i *bleveIndex) Count() (uint64, error) {
+	return i.index.DocCount()
+}
+
+// Get fetches a previously indexed document by id, returning nil if it
+// isn't present. Used to reuse already-extracted text for a newly
+// discovered path with identical content rather than re-running OCR.
+func (i *bleveIndex) Get(id string) (*FileData, error) {
+	doc, err := i.index.Document(id)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching document %q: %v", id, err)
+	}
+	if doc == nil {
+		return nil, nil
+	}
+	fd := &FileData{FullPath: id}
+	for _, field := range doc.Fields {
+		switch f := field.(type) {
+		case *document.TextField:
+			switch f.Name() {
+			case "FileName":
+				fd.FileName = string(f.Value())
+			case "MimeType":
+				fd.MimeType = string(f.Value())
+			case "Text":
+				fd.Text = string(f.Value())
+			}
+		case *document.DateTimeField:
+			if f.Name() == "IndexTime" {
+				if t, err := f.DateTime(); err == nil {
+					fd.IndexTime = t
+				} else {
+					log.Printf("Error parsing IndexTime for document %q: %v", id, err)
+				}
+			}
+		}
+	}
+	return fd, nil
+}
+
+func (i *bleveIndex) Delete(id string) error {
+	if i.readOnly {
+		return fmt.Errorf("Attempt to delete from read-only index %q", i.index.Name())
+	}
+	return i.index.Delete(id)
+}
+
+func (i *bleveIndex) Query(terms []string, opts QueryOptions) (*SearchResultDTO, error) {
 	searchQuery := strings.Join(terms, " ")
 	query := bleve.NewQueryStringQuery(searchQuery)
-	// TODO(jwall): limit, skip, and explain should be configurable.
-	request := bleve.NewSearchRequestOptions(query, *limit, *from, false)
-	// TODO(jwall): This should be configurable too.
-	request.Highlight = bleve.NewHighlightWithStyle("ansi")
+	request := bleve.NewSearchRequestOptions(query, opts.size(), opts.From, opts.Explain)
+	if len(opts.Fields) > 0 {
+		request.Fields = opts.Fields
+	}
+	if opts.Highlight != HighlightNone {
+		request.Highlight = bleve.NewHighlightWithStyle(string(opts.Highlight))
+	}
 
 	result, err := i.index.Search(request)
 	if err != nil {
 		log.Printf("Search Error: %q", err)
 		return nil, err
 	}
-	return result, nil
+	return toSearchResultDTO(result), nil
 }
 
 func (i *bleveIndex) Close() error {
@@ -94,5 +152,16 @@ func NewIndex(indexLocation string) (Index, error) {
 			return nil, fmt.Errorf("Error opening index %q\n", err)
 		}
 	}
-	return &bleveIndex{index}, nil
+	return &bleveIndex{index: index}, nil
+}
+
+// OpenIndexReadOnly opens an existing index for querying only, refusing any
+// writes. This is used by the HTTP server's query-only deployments so that
+// multiple processes can safely share the same index directory.
+func OpenIndexReadOnly(indexLocation string) (Index, error) {
+	index, err := bleve.OpenUsing(indexLocation, map[string]interface{}{"read_only": true})
+	if err != nil {
+		return nil, fmt.Errorf("Error opening read-only index %q\n", err)
+	}
+	return &bleveIndex{index: index, readOnly: true}, nil
 }
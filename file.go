@@ -2,6 +2,7 @@ package main
 
 import (
 	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -57,20 +58,43 @@ func getPixImage(f string) (*lpt.Pix, error) {
 	return lpt.NewPixFromFile(f)
 }
 
-func ocrImageFile(file string) (string, error) {
-	// Create new tess instance and point it to the tessdata location.
-	// Set language to english.
-	t, err := gts.NewTess(filepath.Join(*tessData, "tessdata"), "eng")
+// getPixImagePage rasterizes a single page of a pdf at *pdfDensity, for use
+// when only one page needs OCR rather than the whole document.
+func getPixImagePage(f string, page int) (*lpt.Pix, error) {
+	cmdName, err := exec.LookPath("convert")
 	if err != nil {
-		log.Fatalf("Error while initializing Tess: %s\n", err)
+		return nil, fmt.Errorf("Unable to find convert binary %v", err)
 	}
-	defer t.Close()
+	tmpFName := filepath.Join(os.TempDir(), fmt.Sprintf("%s.p%d.tif", filepath.Base(f), page))
+	src := fmt.Sprintf("%s[%d]", f, page-1)
+	log.Printf("converting %q to %q", src, tmpFName)
+	cmd := exec.Command(cmdName, "-density", fmt.Sprint(*pdfDensity), src, "-depth", "8", tmpFName)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		log.Printf("output: %q", out)
+		return nil, fmt.Errorf("Error converting pdf page with %q err: %v", cmd.Args, err)
+	}
+	return lpt.NewPixFromFile(tmpFName)
+}
 
+func ocrImageFile(file string) (string, error) {
 	pix, err := getPixImage(file)
 	if err != nil {
 		return "", fmt.Errorf("Error while getting pix from file: %s (%s)", file, err)
 	}
 	defer pix.Close()
+	return ocrPix(pix)
+}
+
+// ocrPix runs tesseract OCR against an already rasterized image.
+func ocrPix(pix *lpt.Pix) (string, error) {
+	// Create new tess instance and point it to the tessdata location.
+	// Set language to english.
+	t, err := gts.NewTess(filepath.Join(*tessData, "tessdata"), "eng")
+	if err != nil {
+		log.Fatalf("Error while initializing Tess: %s\n", err)
+	}
+	defer t.Close()
 
 	t.SetPageSegMode(gts.PSM_AUTO_OSD)
 
@@ -113,13 +137,15 @@ func (fd *FileData) Type() string {
 type FileProcessor interface {
 	ShouldProcess(file string) (bool, error)
 	Process(file string) error
+	ProcessUpload(name string, r io.Reader) error
 	Register(mime string, ft FileTranslator) error
 }
 
 type processor struct {
 	defaultMimeTypeHandlers map[string]FileTranslator
-	hashDir                 string
+	store                   *ContentStore
 	index                   Index
+	force                   bool
 }
 
 func (p *processor) registerDefaults() {
@@ -127,15 +153,13 @@ func (p *processor) registerDefaults() {
 		"text":                   getPlainTextContent,
 		"image":                  ocrImageFile,
 		"application/javascript": getPlainTextContent,
-		// TODO(jeremy): We should try the pdf2text application first if
-		// available.
-		"application/pdf": ocrImageFile,
+		"application/pdf":        extractPdfText,
 	}
 
 }
 
-func NewProcessor(hashDir string, index Index) FileProcessor {
-	p := &processor{hashDir: hashDir, index: index}
+func NewProcessor(hashDir string, index Index, force bool) FileProcessor {
+	p := &processor{store: NewContentStore(hashDir), index: index, force: force}
 	p.registerDefaults()
 	return p
 }
@@ -148,87 +172,162 @@ func (p *processor) Register(mime string, ft FileTranslator) error {
 	return nil
 }
 
-func hashFile(file string) ([]byte, error) {
+func hashFile(file string) (string, error) {
 	h := sha256.New()
 	f, err := os.Open(file)
 	defer f.Close()
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 	_, err = io.Copy(h, f)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	return h.Sum([]byte{}), nil
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
-func (p *processor) checkHash(file string, hash []byte) (bool, error) {
-	hashFile := path.Join(p.hashDir, file)
-	if _, err := os.Stat(hashFile); os.IsNotExist(err) {
-		return false, nil
+// finishFile records a newly indexed file's content hash in the store, so
+// that later indexing runs can recognize the same bytes under any path.
+func (p *processor) finishFile(fd *FileData, hash string) error {
+	record := &Record{
+		Hash:      hash,
+		Paths:     []string{fd.FullPath},
+		MimeType:  fd.MimeType,
+		IndexTime: fd.IndexTime,
+		DocIDs:    []string{fd.FullPath},
 	}
-	f, err := os.Open(hashFile)
-	defer f.Close()
+	if fi, err := os.Stat(fd.FullPath); err == nil {
+		record.ModTime = fi.ModTime()
+	}
+	return p.store.Put(record)
+}
+
+// ShouldProcess reports whether file still needs its content extracted and
+// indexed. It hashes file and checks the content-addressed store: a new
+// hash always needs processing; a hash already in the store never does. If
+// this path hasn't been linked to that hash before, it is linked here: the
+// existing extracted text is reused via a secondary bleve document rather
+// than re-running OCR, and the path is added to the record.
+func (p *processor) ShouldProcess(file string) (bool, error) {
+	return p.shouldProcess(file, path.Clean(file))
+}
+
+// shouldProcess is ShouldProcess with the path recorded in the content
+// store (recordPath) split out from the path actually read from disk
+// (statFile), since an uploaded file is hashed from a spooled temp path
+// but must be recorded and linked under its caller-supplied name.
+func (p *processor) shouldProcess(statFile, recordPath string) (bool, error) {
+	fi, err := os.Stat(statFile)
+	if err != nil {
+		return false, err
+	}
+	if fi.Size() > 1000000 {
+		return false, fmt.Errorf("File too large to index %q", recordPath)
+	}
+	if p.force {
+		return true, nil
+	}
+
+	hash, err := hashFile(statFile)
 	if err != nil {
 		return false, err
 	}
-	bs, err := ioutil.ReadAll(f)
+	record, err := p.store.Get(hash)
 	if err != nil {
 		return false, err
 	}
-	if len(bs) != len(hash) {
+	if record == nil {
+		return true, nil
+	}
+
+	if containsPath(record.Paths, recordPath) {
+		log.Printf("Already indexed %q", recordPath)
 		return false, nil
 	}
-	for i, b := range bs {
-		if b != hash[i] {
-			return false, nil
-		}
+
+	log.Printf("%q has the same content as already indexed %v, linking without reprocessing", recordPath, record.Paths)
+	if err := p.addSecondaryDoc(record, recordPath); err != nil {
+		return false, err
 	}
-	return true, nil
+	record.Paths = append(record.Paths, recordPath)
+	return false, p.store.Put(record)
 }
 
-func (p *processor) finishFile(file string) error {
-	h, err := hashFile(file)
+// addSecondaryDoc reuses the already-extracted text from record's primary
+// document to index cleanPath as an additional bleve document, avoiding a
+// redundant OCR/extraction pass over identical bytes.
+func (p *processor) addSecondaryDoc(record *Record, cleanPath string) error {
+	primary, err := p.index.Get(record.DocIDs[0])
 	if err != nil {
 		return err
 	}
+	if primary == nil {
+		return fmt.Errorf("Unable to find primary document %q for hash %q", record.DocIDs[0], record.Hash)
+	}
+	fd := &FileData{
+		FullPath:  cleanPath,
+		FileName:  filepath.Base(cleanPath),
+		MimeType:  primary.MimeType,
+		IndexTime: primary.IndexTime,
+		Text:      primary.Text,
+	}
+	if err := p.index.Put(fd); err != nil {
+		return err
+	}
+	record.DocIDs = append(record.DocIDs, fd.FullPath)
+	return nil
+}
 
-	if _, err := os.Stat(p.hashDir); os.IsNotExist(err) {
-		if err := os.MkdirAll(p.hashDir, os.ModeDir|os.ModePerm); err != nil {
-			return err
+func containsPath(paths []string, p string) bool {
+	for _, existing := range paths {
+		if existing == p {
+			return true
 		}
 	}
+	return false
+}
 
-	fd, err := os.Create(filepath.Join(p.hashDir, file))
-	defer fd.Close()
+// ProcessUpload spools an uploaded byte stream to a temporary file and runs
+// it through the same pipeline as Process, so that files pushed through the
+// HTTP server's `_index` endpoint still go through mime detection, OCR, and
+// hash dedup. The document is indexed and recorded in the content store
+// under the caller-supplied name, not the ephemeral spool path, since the
+// spool path is removed as soon as this function returns.
+func (p *processor) ProcessUpload(name string, r io.Reader) error {
+	tmpFile := filepath.Join(os.TempDir(), fmt.Sprintf("goindexer-upload-%d-%s", time.Now().UnixNano(), filepath.Base(name)))
+	fd, err := os.Create(tmpFile)
 	if err != nil {
+		return fmt.Errorf("Error creating temp file for upload %q: %v", name, err)
+	}
+	defer os.Remove(tmpFile)
+	if _, err := io.Copy(fd, r); err != nil {
+		fd.Close()
+		return fmt.Errorf("Error spooling upload %q: %v", name, err)
+	}
+	if err := fd.Close(); err != nil {
 		return err
 	}
-
-	_, err = fd.Write(h)
-	return err
+	return p.process(tmpFile, path.Clean(name))
 }
 
-func (p *processor) ShouldProcess(file string) (bool, error) {
-	fi, err := os.Stat(file)
-	if fi.Size() > 1000000 {
-		return false, fmt.Errorf("File too large to index %q", file)
-	}
+func (p *processor) Process(file string) error {
+	return p.process(file, path.Clean(file))
+}
 
-	h, err := hashFile(file)
+// process extracts and indexes diskFile's content, but records and indexes
+// it under recordPath rather than diskFile. For Process these are the
+// same path; for ProcessUpload, diskFile is a temp spool path and
+// recordPath is the caller-supplied name.
+func (p *processor) process(diskFile, recordPath string) error {
+	ok, err := p.shouldProcess(diskFile, recordPath)
 	if err != nil {
-		return false, err
+		return err
 	}
-	if ok, _ := p.checkHash(filepath.Base(file), h); ok {
-		log.Printf("Already indexed %q", file)
-		return false, nil
+	if !ok {
+		return nil
 	}
-	return true, nil
-}
 
-func (p *processor) Process(file string) error {
-	// TODO(jeremy): Move the hashing part out of here.
-	ext := filepath.Ext(file)
+	ext := filepath.Ext(recordPath)
 	// TODO(jwall): Do I want to do anything with the params?
 	mt, _, err := mime.ParseMediaType(mime.TypeByExtension(ext))
 	parts := strings.SplitN(mt, "/", 2)
@@ -237,19 +336,19 @@ func (p *processor) Process(file string) error {
 	}
 	fd := FileData{
 		MimeType: mt,
-		FileName: filepath.Base(file),
-		FullPath: path.Clean(file),
+		FileName: filepath.Base(recordPath),
+		FullPath: recordPath,
 		// How to index this properly?
 		IndexTime: time.Now(),
 	}
 	log.Printf("Detected mime category: %q", parts[0])
 	if ft, exists := p.defaultMimeTypeHandlers[mt]; exists {
-		fd.Text, err = ft(file)
+		fd.Text, err = ft(diskFile)
 		if err != nil {
 			return err
 		}
 	} else if ft, exists := p.defaultMimeTypeHandlers[parts[0]]; exists {
-		fd.Text, err = ft(file)
+		fd.Text, err = ft(diskFile)
 		if err != nil {
 			return err
 		}
@@ -257,8 +356,12 @@ func (p *processor) Process(file string) error {
 		return fmt.Errorf("Unhandled file format %q", mt)
 	}
 	log.Printf("Indexing %q", fd.FullPath)
-	if err := p.index.Index(&fd); err != nil {
+	if err := p.index.Put(&fd); err != nil {
+		return err
+	}
+	hash, err := hashFile(diskFile)
+	if err != nil {
 		return err
 	}
-	return p.finishFile(fd.FullPath)
+	return p.finishFile(&fd, hash)
 }
@@ -35,3 +35,12 @@ var hashLocation = flag.String("hash_location", ".indexed_files", "Location wher
 var isQuery = flag.Bool("query", false, "Run a query instead of indexing")
 var isIndex = flag.Bool("index", false, "Run an indexing operation instead of querying")
 var mimeTypeMappings = mimeFlag("mime", "Add a custom mime type mapping.")
+var httpAddr = flag.String("http", "", "Address (e.g. :8080) to serve the REST API on instead of running the CLI.")
+var dataDir = flag.String("data_dir", "data", "Directory containing one subdirectory per named index, used by --http.")
+var readOnly = flag.Bool("read_only", false, "Open indexes read-only, for query-only --http deployments.")
+var pdfTextExtractor = flag.String("pdf_text_extractor", "pdftotext", "How to extract embedded pdf text before falling back to OCR. One of poppler, pdftotext, none.")
+var pdfOcrFallback = flag.Bool("pdf_ocr_fallback", true, "Fall back to OCR for pdf pages whose extracted text looks too sparse to trust.")
+var pdfOcrThreshold = flag.Int("pdf_ocr_threshold", 10, "Minimum non-whitespace characters a pdf page's extracted text must have before skipping OCR.")
+var querySize = flag.Int("size", 10, "Default page size for search queries.")
+var gc = flag.Bool("gc", false, "Walk the content store, dropping records/documents for paths that no longer exist, then exit.")
+var force = flag.Bool("force", false, "Reprocess files even if their content is already indexed.")
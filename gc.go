@@ -0,0 +1,53 @@
+// Copyright 2015 Jeremy Wall (jeremy@marzhillstudios.com)
+// Use of this source code is governed by the Artistic License 2.0.
+// That License is included in the LICENSE file.
+package main
+
+import (
+	"log"
+	"os"
+)
+
+// RunGC walks the content store, dropping any record whose paths have all
+// vanished from disk (along with the bleve documents those paths pointed
+// at), and trims the paths/doc ids out of records that lost some but not
+// all of their paths.
+func RunGC(store *ContentStore, index Index) error {
+	var stale []string
+	err := store.Walk(func(record *Record) error {
+		survivingPaths := make([]string, 0, len(record.Paths))
+		survivingDocIDs := make([]string, 0, len(record.DocIDs))
+		for i, p := range record.Paths {
+			if _, err := os.Stat(p); err == nil {
+				survivingPaths = append(survivingPaths, p)
+				if i < len(record.DocIDs) {
+					survivingDocIDs = append(survivingDocIDs, record.DocIDs[i])
+				}
+				continue
+			}
+			if i < len(record.DocIDs) {
+				log.Printf("Removing document %q for vanished path %q", record.DocIDs[i], p)
+				if err := index.Delete(record.DocIDs[i]); err != nil {
+					return err
+				}
+			}
+		}
+		if len(survivingPaths) == 0 {
+			stale = append(stale, record.Hash)
+			return nil
+		}
+		record.Paths = survivingPaths
+		record.DocIDs = survivingDocIDs
+		return store.Put(record)
+	})
+	if err != nil {
+		return err
+	}
+	for _, hash := range stale {
+		log.Printf("Removing record for hash %q, no surviving paths", hash)
+		if err := store.Remove(hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
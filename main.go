@@ -50,8 +50,8 @@ func main() {
 		os.Exit(0)
 	}
 
-	if !(*isQuery) && !(*isIndex) {
-		fmt.Println("One of --query or --index must be passed")
+	if !(*gc) && *httpAddr == "" && !(*isQuery) && !(*isIndex) {
+		fmt.Println("One of --gc, --http, --query, or --index must be passed")
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
@@ -61,6 +61,27 @@ func main() {
 		mime.AddExtensionType(k, v)
 	}
 
+	if *gc {
+		index, err := NewIndex(*indexLocation)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer index.Close()
+		if err := RunGC(NewContentStore(*hashLocation), index); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
+	if *httpAddr != "" {
+		registry, err := NewIndexRegistry(*dataDir, *readOnly)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer registry.Close()
+		log.Fatalln(RunServer(*httpAddr, registry))
+	}
+
 	index, err := NewIndex(*indexLocation)
 	if err != nil {
 		log.Fatalln(err)
@@ -68,12 +89,17 @@ func main() {
 	defer index.Close()
 
 	if *isQuery {
-		result, err := index.Query(flag.Args())
+		hits, err := QueryAll(index, flag.Args(), DefaultQueryOptions())
 		if err != nil {
 			log.Printf("Error: %q", err)
 			os.Exit(1)
 		}
-		fmt.Println(result)
+		for _, hit := range hits {
+			fmt.Println(hit.FullPath)
+			for field, match := range hit.Fragments {
+				fmt.Printf("  %s: %s\n", field, match.Value)
+			}
+		}
 		return
 	} else if *isIndex {
 		p := NewProcessor(*hashLocation, index, *force)
@@ -0,0 +1,130 @@
+// Copyright 2015 Jeremy Wall (jeremy@marzhillstudios.com)
+// Use of this source code is governed by the Artistic License 2.0.
+// That License is included in the LICENSE file.
+package main
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+	"unicode"
+
+	poppler "github.com/gen2brain/go-poppler"
+)
+
+// extractPdfText is the default FileTranslator for application/pdf. Unlike
+// ocrImageFile, it does not unconditionally rasterize the document: it
+// first tries to pull the embedded text layer out page by page, and only
+// rasterizes + OCRs the pages whose extracted text is too sparse to trust
+// (e.g. scanned pages with no text layer), per *pdfOcrThreshold.
+func extractPdfText(file string) (string, error) {
+	if *pdfTextExtractor == "none" {
+		return ocrImageFile(file)
+	}
+
+	pages, err := pdfPageCount(file)
+	if err != nil {
+		log.Printf("Unable to determine page count for %q, falling back to OCR: %v", file, err)
+		return ocrImageFile(file)
+	}
+
+	texts := make([]string, 0, pages)
+	for page := 1; page <= pages; page++ {
+		text, err := pdfPageText(file, page)
+		if err != nil {
+			log.Printf("Error extracting text from %q page %d: %v", file, page, err)
+		}
+		if nonWhitespaceCount(text) < *pdfOcrThreshold {
+			if !*pdfOcrFallback {
+				texts = append(texts, text)
+				continue
+			}
+			log.Printf("Page %d of %q has too little extracted text, falling back to OCR", page, file)
+			text, err = ocrPdfPage(file, page)
+			if err != nil {
+				return "", fmt.Errorf("Error OCRing page %d of %q: %v", page, file, err)
+			}
+		}
+		texts = append(texts, text)
+	}
+	return strings.Join(texts, "\n"), nil
+}
+
+func nonWhitespaceCount(s string) int {
+	n := 0
+	for _, r := range s {
+		if !unicode.IsSpace(r) {
+			n++
+		}
+	}
+	return n
+}
+
+func pdfPageCount(file string) (int, error) {
+	cmdName, err := exec.LookPath("pdfinfo")
+	if err != nil {
+		return 0, fmt.Errorf("Unable to find pdfinfo binary: %v", err)
+	}
+	out, err := exec.Command(cmdName, file).CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("Error running pdfinfo on %q: %v", file, err)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(line, "Pages:") {
+			return strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "Pages:")))
+		}
+	}
+	return 0, fmt.Errorf("Unable to parse page count from pdfinfo output for %q", file)
+}
+
+func pdfPageText(file string, page int) (string, error) {
+	switch *pdfTextExtractor {
+	case "poppler":
+		return popplerPageText(file, page)
+	case "pdftotext":
+		return pdftotextPageText(file, page)
+	default:
+		return "", fmt.Errorf("Unknown pdf_text_extractor %q", *pdfTextExtractor)
+	}
+}
+
+func pdftotextPageText(file string, page int) (string, error) {
+	cmdName, err := exec.LookPath("pdftotext")
+	if err != nil {
+		return "", fmt.Errorf("Unable to find pdftotext binary: %v", err)
+	}
+	pageStr := strconv.Itoa(page)
+	out, err := exec.Command(cmdName, "-f", pageStr, "-l", pageStr, "-layout", file, "-").Output()
+	if err != nil {
+		return "", fmt.Errorf("Error running pdftotext on %q: %v", file, err)
+	}
+	return string(out), nil
+}
+
+func popplerPageText(file string, page int) (string, error) {
+	doc, err := poppler.Open(file)
+	if err != nil {
+		return "", fmt.Errorf("Error opening %q with poppler: %v", file, err)
+	}
+	defer doc.Close()
+	if page < 1 || page > doc.GetNPages() {
+		return "", fmt.Errorf("Page %d out of range for %q", page, file)
+	}
+	p := doc.GetPage(page - 1)
+	defer p.Close()
+	return p.Text(), nil
+}
+
+// ocrPdfPage rasterizes a single pdf page and runs it through the existing
+// OCR pipeline. Used as the per-page fallback when a page's extracted text
+// looks too sparse to trust.
+func ocrPdfPage(file string, page int) (string, error) {
+	pix, err := getPixImagePage(file, page)
+	if err != nil {
+		return "", err
+	}
+	defer pix.Close()
+	return ocrPix(pix)
+}
@@ -0,0 +1,158 @@
+// Copyright 2015 Jeremy Wall (jeremy@marzhillstudios.com)
+// Use of this source code is governed by the Artistic License 2.0.
+// That License is included in the LICENSE file.
+package main
+
+import (
+	"strings"
+
+	"github.com/blevesearch/bleve"
+)
+
+// MatchLevel describes how thoroughly a field's stored value matched a
+// search query, mirroring Algolia's _highlightResult.matchLevel.
+type MatchLevel string
+
+const (
+	MatchNone    MatchLevel = "none"
+	MatchPartial MatchLevel = "partial"
+	MatchFull    MatchLevel = "full"
+)
+
+// HighlightStyle selects how FieldMatch.Value is marked up, passed straight
+// through to bleve.NewHighlightWithStyle.
+type HighlightStyle string
+
+const (
+	HighlightAnsi HighlightStyle = "ansi"
+	HighlightHTML HighlightStyle = "html"
+	HighlightNone HighlightStyle = "none"
+)
+
+// FieldMatch carries one field's highlighted fragment and how well it
+// matched the query.
+type FieldMatch struct {
+	Value            string     `json:"value"`
+	MatchLevel       MatchLevel `json:"matchLevel"`
+	MatchedWords     []string   `json:"matchedWords"`
+	FullyHighlighted bool       `json:"fullyHighlighted"`
+}
+
+// Hit is a single, JSON-serializable search result.
+type Hit struct {
+	FullPath  string                `json:"fullPath"`
+	FileName  string                `json:"fileName"`
+	MimeType  string                `json:"mimeType"`
+	Score     float64               `json:"score"`
+	Fragments map[string]FieldMatch `json:"fragments"`
+}
+
+// SearchResultDTO is the result of an Index.Query call, in a shape that
+// both the CLI and the HTTP server can consume directly.
+type SearchResultDTO struct {
+	Total uint64 `json:"total"`
+	From  int    `json:"from"`
+	Size  int    `json:"size"`
+	Hits  []Hit  `json:"hits"`
+}
+
+// QueryOptions controls pagination, highlighting, and field selection for
+// an Index.Query call.
+type QueryOptions struct {
+	From      int
+	Size      int
+	Highlight HighlightStyle
+	Fields    []string
+	Explain   bool
+}
+
+// DefaultQueryOptions returns the first page with ansi highlighting and
+// every stored field loaded. Hit.FileName/MimeType rely on FileName/MimeType being among the loaded
+// fields, so this must not request an empty field list.
+func DefaultQueryOptions() QueryOptions {
+	return QueryOptions{Highlight: HighlightAnsi, Fields: []string{"*"}}
+}
+
+func (o QueryOptions) size() int {
+	if o.Size <= 0 {
+		return *querySize
+	}
+	return o.Size
+}
+
+// QueryAll pages through every match for terms, looping From += Size until
+// a page comes back short, so CLI/HTTP callers can stream all matches
+// without each reimplementing the pagination loop.
+func QueryAll(index Index, terms []string, opts QueryOptions) ([]Hit, error) {
+	size := opts.size()
+	opts.Size = size
+	var hits []Hit
+	for {
+		result, err := index.Query(terms, opts)
+		if err != nil {
+			return nil, err
+		}
+		hits = append(hits, result.Hits...)
+		if len(result.Hits) < size {
+			break
+		}
+		opts.From += size
+	}
+	return hits, nil
+}
+
+func toSearchResultDTO(result *bleve.SearchResult) *SearchResultDTO {
+	dto := &SearchResultDTO{
+		Total: result.Total,
+		From:  result.Request.From,
+		Size:  result.Request.Size,
+		Hits:  make([]Hit, 0, len(result.Hits)),
+	}
+	for _, hit := range result.Hits {
+		h := Hit{
+			FullPath:  hit.ID,
+			Score:     hit.Score,
+			Fragments: make(map[string]FieldMatch),
+		}
+		if fileName, ok := hit.Fields["FileName"].(string); ok {
+			h.FileName = fileName
+		}
+		if mimeType, ok := hit.Fields["MimeType"].(string); ok {
+			h.MimeType = mimeType
+		}
+		for field, fragments := range hit.Fragments {
+			h.Fragments[field] = toFieldMatch(fragments, hit.Locations[field])
+		}
+		dto.Hits = append(dto.Hits, h)
+	}
+	return dto
+}
+
+func toFieldMatch(fragments []string, locations bleve.TermLocationMap) FieldMatch {
+	matchedWords := make([]string, 0, len(locations))
+	for term := range locations {
+		matchedWords = append(matchedWords, term)
+	}
+
+	level := MatchNone
+	value := ""
+	fullyHighlighted := false
+	if len(fragments) > 0 {
+		value = strings.Join(fragments, " ")
+		// bleve joins fragments with an ellipsis when a field's highlighted
+		// text doesn't cover the whole value.
+		fullyHighlighted = len(fragments) == 1 && !strings.Contains(value, "…")
+	}
+	if fullyHighlighted {
+		level = MatchFull
+	} else if len(matchedWords) > 0 {
+		level = MatchPartial
+	}
+
+	return FieldMatch{
+		Value:            value,
+		MatchLevel:       level,
+		MatchedWords:     matchedWords,
+		FullyHighlighted: fullyHighlighted,
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright 2015 Jeremy Wall (jeremy@marzhillstudios.com)
+// Use of this source code is governed by the Artistic License 2.0.
+// That License is included in the LICENSE file.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// IndexRegistry multiplexes the set of named bleve indexes discovered under
+// a data directory, one subdirectory per index, so that the HTTP server can
+// query and index against more than one bleve index at a time.
+type IndexRegistry struct {
+	dataDir  string
+	readOnly bool
+	indexes  map[string]Index
+	procs    map[string]FileProcessor
+}
+
+// NewIndexRegistry walks dataDir and registers every immediate subdirectory
+// as a named index, skipping those whose names start with a dot.
+func NewIndexRegistry(dataDir string, readOnly bool) (*IndexRegistry, error) {
+	r := &IndexRegistry{
+		dataDir:  dataDir,
+		readOnly: readOnly,
+		indexes:  make(map[string]Index),
+		procs:    make(map[string]FileProcessor),
+	}
+	entries, err := ioutil.ReadDir(dataDir)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading data directory %q: %v", dataDir, err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		if err := r.register(entry.Name()); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (r *IndexRegistry) register(name string) error {
+	loc := filepath.Join(r.dataDir, name, "index.bleve")
+	var (
+		index Index
+		err   error
+	)
+	if r.readOnly {
+		index, err = OpenIndexReadOnly(loc)
+	} else {
+		index, err = NewIndex(loc)
+	}
+	if err != nil {
+		return fmt.Errorf("Error registering index %q: %v", name, err)
+	}
+	r.indexes[name] = index
+	if !r.readOnly {
+		hashDir := filepath.Join(r.dataDir, name, *hashLocation)
+		r.procs[name] = NewProcessor(hashDir, index, *force)
+	}
+	log.Printf("Registered index %q at %q", name, loc)
+	return nil
+}
+
+// Names returns the registered index names.
+func (r *IndexRegistry) Names() []string {
+	names := make([]string, 0, len(r.indexes))
+	for name := range r.indexes {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Get returns the named index, if registered.
+func (r *IndexRegistry) Get(name string) (Index, bool) {
+	idx, ok := r.indexes[name]
+	return idx, ok
+}
+
+// Processor returns the FileProcessor for the named index. Read-only
+// registries have no processors.
+func (r *IndexRegistry) Processor(name string) (FileProcessor, bool) {
+	p, ok := r.procs[name]
+	return p, ok
+}
+
+func (r *IndexRegistry) Close() error {
+	for name, idx := range r.indexes {
+		if err := idx.Close(); err != nil {
+			log.Printf("Error closing index %q: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Server exposes an IndexRegistry over a small REST API so that clients can
+// query and push documents without running the CLI.
+type Server struct {
+	registry *IndexRegistry
+}
+
+func NewServer(registry *IndexRegistry) *Server {
+	return &Server{registry: registry}
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api", s.handleListIndexes)
+	mux.HandleFunc("/api/", s.handleIndexRoute)
+	return mux
+}
+
+func (s *Server) handleListIndexes(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, http.StatusOK, s.registry.Names())
+}
+
+// handleIndexRoute dispatches /api/{name}/_count, /api/{name}/_search, and
+// /api/{name}/_index based on the trailing path segment.
+func (s *Server) handleIndexRoute(w http.ResponseWriter, req *http.Request) {
+	path := strings.TrimPrefix(req.URL.Path, "/api/")
+	parts := strings.SplitN(path, "/", 2)
+	if len(parts) != 2 {
+		http.NotFound(w, req)
+		return
+	}
+	name, action := parts[0], parts[1]
+	index, ok := s.registry.Get(name)
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unknown index %q", name), http.StatusNotFound)
+		return
+	}
+
+	switch action {
+	case "_count":
+		s.handleCount(w, req, index)
+	case "_search":
+		s.handleSearch(w, req, index)
+	case "_index":
+		s.handleIndex(w, req, name)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (s *Server) handleCount(w http.ResponseWriter, req *http.Request, index Index) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	count, err := index.Count()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]uint64{"count": count})
+}
+
+func (s *Server) handleSearch(w http.ResponseWriter, req *http.Request, index Index) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var body struct {
+		Terms     []string       `json:"terms"`
+		From      int            `json:"from"`
+		Size      int            `json:"size"`
+		Highlight HighlightStyle `json:"highlight"`
+		Fields    []string       `json:"fields"`
+		Explain   bool           `json:"explain"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts := DefaultQueryOptions()
+	opts.From = body.From
+	opts.Size = body.Size
+	opts.Explain = body.Explain
+	if len(body.Fields) > 0 {
+		opts.Fields = body.Fields
+	}
+	if body.Highlight != "" {
+		opts.Highlight = body.Highlight
+	}
+	result, err := index.Query(body.Terms, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) handleIndex(w http.ResponseWriter, req *http.Request, name string) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p, ok := s.registry.Processor(name)
+	if !ok {
+		http.Error(w, fmt.Sprintf("Index %q is read-only", name), http.StatusForbidden)
+		return
+	}
+	file := req.URL.Query().Get("name")
+	if file == "" {
+		http.Error(w, `Missing required "name" query parameter`, http.StatusBadRequest)
+		return
+	}
+	if err := p.ProcessUpload(file, req.Body); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error writing JSON response: %v", err)
+	}
+}
+
+// RunServer starts the HTTP server, blocking until it exits or fails.
+func RunServer(addr string, registry *IndexRegistry) error {
+	log.Printf("Starting HTTP server on %q serving indexes from %q", addr, registry.dataDir)
+	return http.ListenAndServe(addr, NewServer(registry).Handler())
+}
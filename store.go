@@ -0,0 +1,100 @@
+// Copyright 2015 Jeremy Wall (jeremy@marzhillstudios.com)
+// Use of this source code is governed by the Artistic License 2.0.
+// That License is included in the LICENSE file.
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Record is the metadata kept for a single unique file, keyed by the
+// sha256 of its bytes. A Record covers every path we've seen with that
+// content, so indexing the same bytes under a second path links to the
+// existing bleve documents instead of re-running extraction.
+type Record struct {
+	Hash      string    `json:"hash"`
+	Paths     []string  `json:"paths"`
+	MimeType  string    `json:"mimeType"`
+	ModTime   time.Time `json:"modTime"`
+	IndexTime time.Time `json:"indexTime"`
+	DocIDs    []string  `json:"docIds"`
+}
+
+// ContentStore is a content-addressed store of Records on disk, replacing
+// the old hashDir layout that mirrored the indexed filesystem paths.
+// Records live at <dir>/<first two hash chars>/<rest of hash>.json.
+type ContentStore struct {
+	dir string
+}
+
+func NewContentStore(dir string) *ContentStore {
+	return &ContentStore{dir: dir}
+}
+
+func (s *ContentStore) recordPath(hash string) string {
+	return filepath.Join(s.dir, hash[:2], hash[2:]+".json")
+}
+
+// Get returns the record for hash, or nil if no such content has been
+// indexed before.
+func (s *ContentStore) Get(hash string) (*Record, error) {
+	bs, err := ioutil.ReadFile(s.recordPath(hash))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	record := &Record{}
+	if err := json.Unmarshal(bs, record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// Put writes (or overwrites) record under its hash.
+func (s *ContentStore) Put(record *Record) error {
+	p := s.recordPath(record.Hash)
+	if err := os.MkdirAll(filepath.Dir(p), os.ModeDir|os.ModePerm); err != nil {
+		return err
+	}
+	bs, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p, bs, 0644)
+}
+
+// Remove deletes the record for hash.
+func (s *ContentStore) Remove(hash string) error {
+	return os.Remove(s.recordPath(hash))
+}
+
+// Walk calls fn once for every record currently in the store.
+func (s *ContentStore) Walk(fn func(record *Record) error) error {
+	if _, err := os.Stat(s.dir); os.IsNotExist(err) {
+		return nil
+	}
+	return filepath.Walk(s.dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(p, ".json") {
+			return nil
+		}
+		bs, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		record := &Record{}
+		if err := json.Unmarshal(bs, record); err != nil {
+			return err
+		}
+		return fn(record)
+	})
+}